@@ -0,0 +1,460 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheVersion is bumped whenever the on-disk shape of repoCacheData
+// changes, so a stale cache from an older gitbase is rebuilt instead
+// of misread.
+const cacheVersion = 1
+
+/*
+ DocumentRef identifies a single document inside a collection, as
+ returned by Repository.Search.
+*/
+type DocumentRef struct {
+	Collection string
+	Key        string
+}
+
+/*
+ documentCacheEntry is the cached metadata for one document path,
+ keyed by its path relative to the repository root. Tokens is kept
+ alongside the metadata so a later update or removal of the path can
+ retract exactly the index entries it added, without a full index
+ rebuild.
+*/
+type documentCacheEntry struct {
+	CommitId string
+	Size     int64
+	Tokens   []string
+}
+
+/*
+ repoCacheData is the on-disk representation of a RepoCache.
+*/
+type repoCacheData struct {
+	Version    int
+	HeadCommit string
+	Documents  map[string]*documentCacheEntry
+	Index      map[string][]string // lowercased word -> document paths
+}
+
+func newRepoCacheData() *repoCacheData {
+	return &repoCacheData{
+		Version:   cacheVersion,
+		Documents: map[string]*documentCacheEntry{},
+		Index:     map[string][]string{},
+	}
+}
+
+/*
+ RepoCache is a manifest of the collections and documents in a
+ Repository, together with a full-text index of their contents. It is
+ persisted under `<repo>/.git/gitbase/cache.json` and rebuilt
+ incrementally: only the commits made since the cache's recorded HEAD
+ are walked, and only the paths each of those commits actually touched
+ are updated.
+*/
+type RepoCache struct {
+	sync.Mutex
+
+	path string
+	data *repoCacheData
+}
+
+func (self *Repository) cacheFilePath() string {
+	return self.Worktree.Filesystem.Join(".git", "gitbase", "cache.json")
+}
+
+/*
+ cache lazily loads (or creates) the RepoCache for this repository
+*/
+func (self *Repository) cache() (*RepoCache, error) {
+	self.cacheLock.Lock()
+	defer self.cacheLock.Unlock()
+
+	if self.repoCache != nil {
+		return self.repoCache, nil
+	}
+
+	cache, err := self.loadRepoCache()
+	if err != nil {
+		return nil, err
+	}
+
+	self.repoCache = cache
+	return cache, nil
+}
+
+func (self *Repository) loadRepoCache() (*RepoCache, error) {
+	path := self.cacheFilePath()
+	cache := &RepoCache{path: path, data: newRepoCacheData()}
+
+	file, err := self.Worktree.Filesystem.Open(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	raw, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data := newRepoCacheData()
+	if err := json.Unmarshal(raw, data); err != nil || data.Version != cacheVersion {
+		// Corrupt or outdated cache: rebuild from scratch rather than
+		// failing to open the repository.
+		return cache, nil
+	}
+
+	cache.data = data
+	return cache, nil
+}
+
+func (self *RepoCache) persist(repo *Repository) error {
+	self.Lock()
+	defer self.Unlock()
+
+	fs := repo.Worktree.Filesystem
+
+	dir := fs.Join(".git", "gitbase")
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(self.data)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := fs.TempFile(dir, "cache.json.tmp-")
+	if err != nil {
+		return err
+	}
+	defer fs.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return fs.Rename(tmp.Name(), self.path)
+}
+
+/*
+ removeDocument retracts path's metadata and every index entry it
+ contributed, e.g. because it was deleted or is about to be
+ overwritten by a newer revision
+*/
+func (self *RepoCache) removeDocument(path string) {
+	entry, ok := self.data.Documents[path]
+	if !ok {
+		return
+	}
+
+	for _, word := range entry.Tokens {
+		remaining := removeString(self.data.Index[word], path)
+		if len(remaining) == 0 {
+			delete(self.data.Index, word)
+		} else {
+			self.data.Index[word] = remaining
+		}
+	}
+
+	delete(self.data.Documents, path)
+}
+
+/*
+ indexDocument (re-)records path at the revision held in file, first
+ retracting whatever stale entry (and index tokens) it may have had
+*/
+func (self *RepoCache) indexDocument(path, commitId string, file *object.File) {
+	self.removeDocument(path)
+
+	entry := &documentCacheEntry{CommitId: commitId, Size: file.Size}
+
+	if contents, err := file.Contents(); err == nil {
+		entry.Tokens = tokenize(contents)
+		for _, word := range entry.Tokens {
+			if !containsString(self.data.Index[word], path) {
+				self.data.Index[word] = append(self.data.Index[word], path)
+			}
+		}
+	}
+
+	self.data.Documents[path] = entry
+}
+
+/*
+ refreshCache brings the cache up to date with HEAD, walking only the
+ commits made since the cache's recorded HEAD commit and, for each,
+ only the paths its diff against its parent actually touched
+*/
+func (self *Repository) refreshCache() (*RepoCache, error) {
+	cache, err := self.cache()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := self.gitRepo.Head()
+	if err != nil {
+		// No commits yet: nothing to index.
+		return cache, nil
+	}
+
+	if cache.data.HeadCommit == head.Hash().String() {
+		return cache, nil
+	}
+
+	commitIter, err := self.gitRepo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	defer commitIter.Close()
+
+	visited := map[string]bool{}
+
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		if c.Hash.String() == cache.data.HeadCommit {
+			return storerStop
+		}
+
+		return applyCommitToCache(cache, c, visited)
+	})
+	if err != nil && err != storerStop {
+		return nil, err
+	}
+
+	cache.data.HeadCommit = head.Hash().String()
+	if err := cache.persist(self); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+/*
+ applyCommitToCache updates cache with the paths commit c touched,
+ skipping any path already visited earlier in the same walk (commits
+ are walked newest-first, so the first time a path is seen is always
+ its current state)
+*/
+func applyCommitToCache(cache *RepoCache, c *object.Commit, visited map[string]bool) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	parent, err := c.Parent(0)
+	if err != nil {
+		// Root commit: everything in its tree is a fresh insert.
+		return tree.Files().ForEach(func(f *object.File) error {
+			if visited[f.Name] {
+				return nil
+			}
+			visited[f.Name] = true
+			cache.indexDocument(f.Name, c.Hash.String(), f)
+			return nil
+		})
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			continue
+		}
+
+		var path string
+		switch action {
+		case merkletrie.Insert, merkletrie.Modify:
+			path = change.To.Name
+		case merkletrie.Delete:
+			path = change.From.Name
+		}
+
+		if path == "" || visited[path] {
+			continue
+		}
+		visited[path] = true
+
+		if action == merkletrie.Delete {
+			cache.removeDocument(path)
+			continue
+		}
+
+		file, err := change.To.Tree.TreeEntryFile(&change.To.TreeEntry)
+		if err != nil {
+			continue
+		}
+
+		cache.indexDocument(path, c.Hash.String(), file)
+	}
+
+	return nil
+}
+
+// storerStop is a sentinel used to break out of a commit walk early,
+// mirroring storer.ErrStop's role in go-git's own iterators.
+var storerStop = errStop{}
+
+type errStop struct{}
+
+func (errStop) Error() string { return "stop" }
+
+func containsString(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(items []string, item string) []string {
+	out := items[:0]
+	for _, i := range items {
+		if i != item {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func tokenize(content string) []string {
+	fields := strings.FieldsFunc(content, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	})
+
+	seen := map[string]bool{}
+	words := []string{}
+	for _, field := range fields {
+		word := strings.ToLower(field)
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+
+	return words
+}
+
+/*
+ ListCollections returns the names of all collections (top-level
+ directories holding documents), backed by the RepoCache
+*/
+func (self *Repository) ListCollections() ([]string, error) {
+	cache, err := self.refreshCache()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for path := range cache.data.Documents {
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := parts[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+/*
+ Search looks up documents whose contents contain every word of query,
+ via the repository's full-text index
+*/
+func (self *Repository) Search(query string) ([]DocumentRef, error) {
+	cache, err := self.refreshCache()
+	if err != nil {
+		return nil, err
+	}
+
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	matches := map[string]bool{}
+	for i, word := range words {
+		paths := cache.data.Index[word]
+		if i == 0 {
+			for _, p := range paths {
+				matches[p] = true
+			}
+			continue
+		}
+
+		next := map[string]bool{}
+		for _, p := range paths {
+			if matches[p] {
+				next[p] = true
+			}
+		}
+		matches = next
+	}
+
+	refs := []DocumentRef{}
+	for path := range matches {
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		refs = append(refs, DocumentRef{Collection: parts[0], Key: parts[1]})
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Collection != refs[j].Collection {
+			return refs[i].Collection < refs[j].Collection
+		}
+		return refs[i].Key < refs[j].Key
+	})
+
+	return refs, nil
+}
+
+/*
+ Lazily-initialized RepoCache for a Repository
+*/
+type cacheState struct {
+	cacheLock sync.Mutex
+	repoCache *RepoCache
+}
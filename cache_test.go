@@ -0,0 +1,103 @@
+package gitbase
+
+import "testing"
+
+func TestSearchFindsCommittedDocument(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("hello world"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	refs, err := repo.Search("hello")
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+
+	if len(refs) != 1 || refs[0].Collection != "programs" || refs[0].Key != "1" {
+		t.Fatalf("Search() = %v, want [{programs 1}]", refs)
+	}
+}
+
+// Regression test: overwriting a document must retract the index
+// entries its previous revision contributed, not just add new ones.
+func TestSearchReflectsOverwrittenDocument(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("alpha"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if refs, err := repo.Search("alpha"); err != nil || len(refs) != 1 {
+		t.Fatalf("Search(alpha) before overwrite = %v, %v, want one match", refs, err)
+	}
+
+	if err := repo.Put("programs/1", []byte("beta"), "overwrite program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	refs, err := repo.Search("alpha")
+	if err != nil {
+		t.Fatalf("Search(alpha) error = %v", err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("Search(alpha) after overwrite = %v, want no matches", refs)
+	}
+
+	refs, err = repo.Search("beta")
+	if err != nil {
+		t.Fatalf("Search(beta) error = %v", err)
+	}
+	if len(refs) != 1 {
+		t.Fatalf("Search(beta) after overwrite = %v, want one match", refs)
+	}
+}
+
+func TestSearchOmitsRemovedDocument(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("alpha"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if err := repo.Remove("programs/1", "remove program"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	refs, err := repo.Search("alpha")
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("Search() after Remove() = %v, want no matches", refs)
+	}
+}
+
+func TestListCollections(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("alpha"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	names, err := repo.ListCollections()
+	if err != nil {
+		t.Fatalf("ListCollections() error = %v", err)
+	}
+
+	if len(names) != 1 || names[0] != "programs" {
+		t.Fatalf("ListCollections() = %v, want [programs]", names)
+	}
+}
@@ -0,0 +1,204 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ clockTrailerKey is the commit message trailer used to stamp the
+ Lamport value a commit advanced a named clock to, e.g.
+ "Gitbase-Clock: programs=42".
+*/
+const clockTrailerKey = "Gitbase-Clock"
+
+/*
+ Get (or lazily load/create) the named Lamport clock for this repository
+*/
+func (self *Repository) GetOrCreateClock(name string) (*LamportClock, error) {
+	self.clocksLock.Lock()
+	defer self.clocksLock.Unlock()
+
+	if self.clocks == nil {
+		self.clocks = map[string]*LamportClock{}
+	}
+
+	if clock, ok := self.clocks[name]; ok {
+		return clock, nil
+	}
+
+	clock, err := self.loadClock(name)
+	if err != nil {
+		return nil, err
+	}
+
+	self.clocks[name] = clock
+	return clock, nil
+}
+
+func (self *Repository) clocksDir() string {
+	return self.Worktree.Filesystem.Join(".git", "gitbase", "clocks")
+}
+
+func (self *Repository) clockPath(name string) string {
+	return self.Worktree.Filesystem.Join(self.clocksDir(), name)
+}
+
+func (self *Repository) loadClock(name string) (*LamportClock, error) {
+	file, err := self.Worktree.Filesystem.Open(self.clockPath(name))
+	if err == nil {
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return newLamportClock(name, LamportTime(t)), nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// No persisted clock file: rebuild it from commit trailers rather
+	// than starting over at zero.
+	t, err := self.rebuildClockFromHistory(name)
+	if err != nil {
+		return nil, err
+	}
+
+	clock := newLamportClock(name, t)
+	if err := self.persistClock(clock); err != nil {
+		return nil, err
+	}
+
+	return clock, nil
+}
+
+/*
+ persistClock writes the clock value through the repository's worktree
+ filesystem, using write-to-temp-then-rename so a crash can never leave
+ a torn value behind
+*/
+func (self *Repository) persistClock(clock *LamportClock) error {
+	fs := self.Worktree.Filesystem
+
+	dir := self.clocksDir()
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := fs.TempFile(dir, clock.name+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer fs.Remove(tmp.Name())
+
+	if _, err := tmp.Write([]byte(strconv.FormatUint(uint64(clock.Time()), 10))); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return fs.Rename(tmp.Name(), self.clockPath(clock.name))
+}
+
+/*
+ rebuildClockFromHistory walks the commits reachable from HEAD and
+ returns the highest value ever stamped for the named clock, or zero
+ if the clock has never been witnessed (including on a repository that
+ has no commits yet)
+*/
+func (self *Repository) rebuildClockFromHistory(name string) (LamportTime, error) {
+	head, err := self.gitRepo.Head()
+	if err != nil {
+		// A freshly initialized repository has no HEAD yet.
+		return 0, nil
+	}
+
+	commitIter, err := self.gitRepo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return 0, err
+	}
+	defer commitIter.Close()
+
+	prefix := fmt.Sprintf("%s: %s=", clockTrailerKey, name)
+
+	var best LamportTime
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		for _, line := range strings.Split(c.Message, "\n") {
+			if !strings.HasPrefix(line, prefix) {
+				continue
+			}
+
+			t, err := strconv.ParseUint(strings.TrimPrefix(line, prefix), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if LamportTime(t) > best {
+				best = LamportTime(t)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return best, nil
+}
+
+/*
+ In-memory clock cache for a Repository
+*/
+type clocksState struct {
+	clocksLock sync.Mutex
+	clocks     map[string]*LamportClock
+}
+
+/*
+ Commit staged changes like Commit, but first increment the named
+ Lamport clock and stamp its new value as a trailer on the message
+*/
+func (self *Repository) CommitWithClock(reason, clockName string) error {
+	clock, err := self.GetOrCreateClock(clockName)
+	if err != nil {
+		return err
+	}
+
+	t := clock.Increment()
+	if err := self.persistClock(clock); err != nil {
+		return err
+	}
+
+	stamped := fmt.Sprintf("%s\n\n%s: %s=%d", reason, clockTrailerKey, clockName, t)
+	return self.Commit(stamped)
+}
+
+/*
+ Combined Add + CommitWithClock for convenience
+*/
+func (self *Repository) CommitAllWithClock(reason, clockName string) error {
+	if err := self.StageChanges(); err != nil {
+		return err
+	}
+
+	return self.CommitWithClock(reason, clockName)
+}
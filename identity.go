@@ -0,0 +1,199 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"golang.org/x/crypto/openpgp"
+
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrKeyNotFound = errors.New("no pgp key found for that email")
+)
+
+/*
+ CommitOptions customizes the identity and signing of a single commit.
+ Author/Committer default to the repository's default identity (see
+ SetDefaultIdentity) when nil, and Committer defaults to Author when
+ only Author is given. Setting SignKey GPG-signs the commit.
+*/
+type CommitOptions struct {
+	Author    *object.Signature
+	Committer *object.Signature
+	SignKey   *openpgp.Entity
+}
+
+/*
+ Default commit identity for this repository
+*/
+type identityState struct {
+	defaultAuthorName  string
+	defaultAuthorEmail string
+}
+
+/*
+ Set the name/email commits are attributed to, overriding `.git/config`
+*/
+func (self *Repository) SetDefaultIdentity(name, email string) {
+	self.defaultAuthorName = name
+	self.defaultAuthorEmail = email
+}
+
+/*
+ Resolve name/email to commit as: explicit identity, then .git/config,
+ then the historical "gitbase"/"git@gitbase" pair
+*/
+func (self *Repository) defaultIdentity() (string, string) {
+	if self.defaultAuthorName != "" || self.defaultAuthorEmail != "" {
+		return self.defaultAuthorName, self.defaultAuthorEmail
+	}
+
+	if cfg, err := self.gitRepo.Config(); err == nil {
+		name := cfg.Raw.Section("user").Option("name")
+		email := cfg.Raw.Section("user").Option("email")
+		if name != "" || email != "" {
+			return name, email
+		}
+	}
+
+	return "gitbase", "git@gitbase"
+}
+
+func (self *Repository) resolveCommitOptions(opts CommitOptions) *git.CommitOptions {
+	author := opts.Author
+	if author == nil {
+		name, email := self.defaultIdentity()
+		author = &object.Signature{Name: name, Email: email, When: time.Now()}
+	}
+
+	committer := opts.Committer
+	if committer == nil {
+		committer = author
+	}
+
+	return &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+		SignKey:   opts.SignKey,
+	}
+}
+
+/*
+ Commit staged changes like Commit, but attributed/signed per opts
+*/
+func (self *Repository) CommitAs(reason string, opts CommitOptions) error {
+	_, err := self.Worktree.Commit(reason, self.resolveCommitOptions(opts))
+	return err
+}
+
+/*
+ Combined Add + CommitAs for convenience
+*/
+func (self *Repository) CommitAllAs(reason string, opts CommitOptions) error {
+	if err := self.StageChanges(); err != nil {
+		return err
+	}
+
+	return self.CommitAs(reason, opts)
+}
+
+/*
+ Put with an explicit commit identity/signature
+*/
+func (self *Repository) PutAs(key string, document []byte, reason string, opts CommitOptions) error {
+	self.Lock()
+	defer self.Unlock()
+
+	file, err := self.Worktree.Filesystem.Create(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(document)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return self.CommitAllAs(reason, opts)
+}
+
+/*
+ Remove with an explicit commit identity/signature
+*/
+func (self *Repository) RemoveAs(key string, reason string, opts CommitOptions) error {
+	self.Lock()
+	defer self.Unlock()
+
+	if err := self.Worktree.Filesystem.Remove(key); err != nil {
+		return err
+	}
+
+	return self.CommitAllAs(reason, opts)
+}
+
+/*
+ Keyring looks up PGP keys by the email they were issued for
+*/
+type Keyring struct {
+	entities openpgp.EntityList
+}
+
+/*
+ Wrap an already-loaded openpgp.EntityList as a Keyring
+*/
+func NewKeyring(entities openpgp.EntityList) *Keyring {
+	return &Keyring{entities: entities}
+}
+
+/*
+ Load the user's secret keyring from ~/.gnupg
+*/
+func LoadGnupgKeyring() (*Keyring, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadKeyringFromDir(filepath.Join(home, ".gnupg"))
+}
+
+/*
+ Load a secring.gpg secret keyring from dir
+*/
+func LoadKeyringFromDir(dir string) (*Keyring, error) {
+	f, err := os.Open(filepath.Join(dir, "secring.gpg"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keyring{entities: entities}, nil
+}
+
+/*
+ Return the first key in the keyring issued to email
+*/
+func (self *Keyring) KeyForEmail(email string) (*openpgp.Entity, error) {
+	for _, entity := range self.entities {
+		for _, identity := range entity.Identities {
+			if identity.UserId.Email == email {
+				return entity, nil
+			}
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,37 @@
+package gitbase
+
+import "testing"
+
+func TestDefaultIdentityFallsBackWhenUnset(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	name, email := repo.defaultIdentity()
+	if name != "gitbase" || email != "git@gitbase" {
+		t.Fatalf("defaultIdentity() = (%q, %q), want (%q, %q)", name, email, "gitbase", "git@gitbase")
+	}
+}
+
+func TestSetDefaultIdentityOverrides(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	repo.SetDefaultIdentity("Ada", "ada@example.com")
+
+	name, email := repo.defaultIdentity()
+	if name != "Ada" || email != "ada@example.com" {
+		t.Fatalf("defaultIdentity() = (%q, %q), want (%q, %q)", name, email, "Ada", "ada@example.com")
+	}
+}
+
+func TestKeyForEmailNotFound(t *testing.T) {
+	keyring := NewKeyring(nil)
+
+	if _, err := keyring.KeyForEmail("nobody@example.com"); err != ErrKeyNotFound {
+		t.Fatalf("KeyForEmail() error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,65 @@
+package gitbase
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+LamportTime is a single tick of a LamportClock.
+*/
+type LamportTime uint64
+
+/*
+LamportClock is a thread-safe monotonic counter used to causally order
+events (such as document edits) across replicas of a repository,
+without relying on wall-clock time.
+*/
+type LamportClock struct {
+	sync.Mutex
+
+	name string
+	time LamportTime
+}
+
+func newLamportClock(name string, time LamportTime) *LamportClock {
+	return &LamportClock{name: name, time: time}
+}
+
+/*
+ Time returns the current value of the clock.
+*/
+func (self *LamportClock) Time() LamportTime {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.time
+}
+
+/*
+ Increment advances the clock by one tick and returns the new value.
+*/
+func (self *LamportClock) Increment() LamportTime {
+	self.Lock()
+	defer self.Unlock()
+
+	self.time++
+	return self.time
+}
+
+/*
+ Witness folds an observed remote time into the clock, advancing it
+ past the observed value if necessary.
+*/
+func (self *LamportClock) Witness(t LamportTime) {
+	self.Lock()
+	defer self.Unlock()
+
+	if t >= self.time {
+		self.time = t + 1
+	}
+}
+
+func (self *LamportClock) String() string {
+	return fmt.Sprintf("%s=%d", self.name, self.Time())
+}
@@ -0,0 +1,37 @@
+package gitbase
+
+import "testing"
+
+func TestLamportClockIncrement(t *testing.T) {
+	clock := newLamportClock("programs", 0)
+
+	if got := clock.Increment(); got != 1 {
+		t.Fatalf("Increment() = %d, want 1", got)
+	}
+
+	if got := clock.Increment(); got != 2 {
+		t.Fatalf("Increment() = %d, want 2", got)
+	}
+
+	if got := clock.Time(); got != 2 {
+		t.Fatalf("Time() = %d, want 2", got)
+	}
+}
+
+func TestLamportClockWitnessAdvances(t *testing.T) {
+	clock := newLamportClock("programs", 5)
+
+	clock.Witness(10)
+	if got := clock.Time(); got != 11 {
+		t.Fatalf("Time() after Witness(10) = %d, want 11", got)
+	}
+}
+
+func TestLamportClockWitnessDoesNotRewind(t *testing.T) {
+	clock := newLamportClock("programs", 20)
+
+	clock.Witness(3)
+	if got := clock.Time(); got != 20 {
+		t.Fatalf("Time() after Witness(3) = %d, want 20", got)
+	}
+}
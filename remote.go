@@ -0,0 +1,193 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+
+	"errors"
+	"log"
+	"os"
+)
+
+var (
+	// ErrRecloneRequiresOnDiskRepository is returned by Reclone (and,
+	// via it, PullOrReclone's recovery path) when called on a
+	// repository that isn't backed by a real OS path, e.g. one built
+	// with NewMemoryRepository or a custom storage.Storer. Wiping and
+	// re-cloning only makes sense against an actual directory.
+	ErrRecloneRequiresOnDiskRepository = errors.New("reclone requires an on-disk repository")
+)
+
+/*
+AuthMethod is the credential a remote operation (Clone, Pull, Push)
+authenticates with. It is satisfied by go-git's transport.AuthMethod,
+so any of its implementations can be used directly; the constructors
+below cover the common cases.
+*/
+type AuthMethod = transport.AuthMethod
+
+/*
+ NewBasicAuth builds an AuthMethod for HTTP(S) remotes authenticating
+ with a username and password or token.
+*/
+func NewBasicAuth(username, token string) AuthMethod {
+	return &http.BasicAuth{
+		Username: username,
+		Password: token,
+	}
+}
+
+/*
+ NewSSHKeyAuth builds an AuthMethod from a private key file on disk,
+ e.g. ~/.ssh/id_rsa. password may be empty for unencrypted keys.
+*/
+func NewSSHKeyAuth(user, privateKeyFile, password string) (AuthMethod, error) {
+	return ssh.NewPublicKeysFromFile(user, privateKeyFile, password)
+}
+
+/*
+ NewSSHAgentAuth builds an AuthMethod that defers to a running
+ ssh-agent, identified by the SSH_AUTH_SOCK environment variable.
+*/
+func NewSSHAgentAuth(user string) (AuthMethod, error) {
+	return ssh.NewSSHAgentAuth(user)
+}
+
+/*
+Clone checks out a remote repository into path, turning it into a
+gitbase Repository.
+*/
+func Clone(url, path string, auth AuthMethod) (*Repository, error) {
+	gitRepo, err := git.PlainClone(path, false, &git.CloneOptions{
+		URL:  url,
+		Auth: auth,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := gitRepo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		BasePath: path,
+		Worktree: worktree,
+		gitRepo:  gitRepo,
+		onDisk:   true,
+	}, nil
+}
+
+/*
+ AddRemote registers a remote named name pointing at url.
+*/
+func (self *Repository) AddRemote(name, url string) error {
+	_, err := self.gitRepo.CreateRemote(&config.RemoteConfig{
+		Name: name,
+		URLs: []string{url},
+	})
+	return err
+}
+
+/*
+ Pull fetches and fast-forwards the worktree from remote.
+ git.NoErrAlreadyUpToDate is treated as success.
+*/
+func (self *Repository) Pull(remote string, auth AuthMethod) error {
+	self.Lock()
+	defer self.Unlock()
+
+	err := self.Worktree.Pull(&git.PullOptions{
+		RemoteName: remote,
+		Auth:       auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+
+	return err
+}
+
+/*
+ isRecloneableError reports whether err is one of the specific
+ non-fast-forward or local-corruption conditions PullOrReclone is
+ allowed to recover from by wiping and re-cloning. Anything else
+ (connectivity, auth, an unknown remote, ...) is returned to the
+ caller untouched, since wiping BasePath wouldn't fix it and would
+ destroy local work for no reason.
+*/
+func isRecloneableError(err error) bool {
+	if err == git.ErrNonFastForwardUpdate || err == git.ErrForceNeeded {
+		return true
+	}
+
+	return errors.Is(err, plumbing.ErrObjectNotFound)
+}
+
+/*
+ PullOrReclone tries a regular Pull first; if that fails with a
+ non-fast-forward history or local corruption (see
+ isRecloneableError), it falls back to wiping BasePath and re-cloning
+ url from scratch. Any other error (connectivity, auth, ...) is
+ returned as-is.
+*/
+func (self *Repository) PullOrReclone(remote, url string, auth AuthMethod) error {
+	err := self.Pull(remote, auth)
+	if err == nil {
+		return nil
+	}
+
+	if !isRecloneableError(err) {
+		return err
+	}
+
+	log.Println("gitbase: pull failed, reclone recovery:", err)
+	return self.Reclone(url, auth)
+}
+
+/*
+ Reclone wipes BasePath and replaces it with a fresh clone of url.
+ It is the recovery path for a local repository that can no longer be
+ reconciled with its remote by a normal Pull.
+*/
+func (self *Repository) Reclone(url string, auth AuthMethod) error {
+	if !self.onDisk {
+		return ErrRecloneRequiresOnDiskRepository
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	if err := os.RemoveAll(self.BasePath); err != nil {
+		return err
+	}
+
+	fresh, err := Clone(url, self.BasePath, auth)
+	if err != nil {
+		return err
+	}
+
+	self.Worktree = fresh.Worktree
+	self.gitRepo = fresh.gitRepo
+	return nil
+}
+
+/*
+ Push uploads local commits to remote.
+*/
+func (self *Repository) Push(remote string, auth AuthMethod) error {
+	err := self.gitRepo.Push(&git.PushOptions{
+		RemoteName: remote,
+		Auth:       auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,66 @@
+package gitbase
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func TestRecloneRefusesNonDiskRepository(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Reclone("https://example.com/repo.git", nil); err != ErrRecloneRequiresOnDiskRepository {
+		t.Fatalf("Reclone() error = %v, want %v", err, ErrRecloneRequiresOnDiskRepository)
+	}
+}
+
+func TestNewBasicAuth(t *testing.T) {
+	auth := NewBasicAuth("user", "token")
+
+	if auth.Name() != "http-basic-auth" {
+		t.Fatalf("auth.Name() = %q, want %q", auth.Name(), "http-basic-auth")
+	}
+}
+
+// Regression test: a connectivity/config failure (here, an unknown
+// remote name) must be returned to the caller as-is, not treated as
+// grounds to wipe and reclone the repository.
+func TestPullOrRecloneDoesNotWipeOnConnectivityError(t *testing.T) {
+	dir := t.TempDir()
+
+	repo, err := NewRepository(dir)
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("alpha"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if err := repo.PullOrReclone("origin", "https://example.invalid/repo.git", nil); err == nil {
+		t.Fatal("PullOrReclone() error = nil, want a non-nil error for a missing remote")
+	}
+
+	if _, err := repo.Worktree.Filesystem.Stat(filepath.Join("programs", "1")); err != nil {
+		t.Fatalf("repository was wiped by PullOrReclone: %v", err)
+	}
+}
+
+func TestIsRecloneableError(t *testing.T) {
+	if isRecloneableError(nil) {
+		t.Fatal("isRecloneableError(nil) = true, want false")
+	}
+
+	if !isRecloneableError(git.ErrNonFastForwardUpdate) {
+		t.Fatal("isRecloneableError(ErrNonFastForwardUpdate) = false, want true")
+	}
+
+	if isRecloneableError(errors.New("some unrelated connectivity error")) {
+		t.Fatal("isRecloneableError(unrelated error) = true, want false")
+	}
+}
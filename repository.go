@@ -2,14 +2,16 @@ package gitbase
 
 import (
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
 
+	"io"
 	"io/ioutil"
-	"path/filepath"
 
 	"errors"
-	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -56,11 +58,20 @@ var (
 
 type Repository struct {
 	sync.RWMutex
+	clocksState
+	cacheState
+	identityState
 
 	BasePath string
 	Worktree *git.Worktree
 
 	gitRepo *git.Repository
+
+	// onDisk is true only for repositories backed by a real OS path
+	// (NewRepository, Clone), as opposed to NewMemoryRepository or a
+	// custom storage.Storer. Remote recovery paths that wipe and
+	// re-clone a directory (Reclone) require it.
+	onDisk bool
 }
 
 /*
@@ -88,53 +99,27 @@ func repositoryCanInitialize(path string) error {
 }
 
 /*
- Open and (if needed) initialize repository
-*/
-func NewRepository(path string) (*Repository, error) {
+ Stage changes in repository
 
-	// Assert path exists
-	err := os.MkdirAll(path, 0755)
+ Worktree.Add(".") only walks paths that still exist on disk, so a
+ path deleted from the worktree is never noticed and would otherwise
+ stay committed forever; those removals are staged explicitly first.
+*/
+func (self *Repository) StageChanges() error {
+	status, err := self.Worktree.Status()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Check if we can open this repository
-	gitRepo, err := git.PlainOpen(path)
-	if err != nil {
-		log.Println("Initializing repository:", path)
-		err = repositoryCanInitialize(path)
-		if err != nil {
-			// Path exists, but we can not initialize
-			return nil, err
-		}
-
-		// Initialize git repo
-		gitRepo, err = git.PlainInit(path, false)
-		if err != nil {
-			return nil, err
+	for path, fileStatus := range status {
+		if fileStatus.Worktree == git.Deleted {
+			if _, err := self.Worktree.Remove(path); err != nil {
+				return err
+			}
 		}
 	}
 
-	// Open worktree
-	worktree, err := gitRepo.Worktree()
-	if err != nil {
-		return nil, err
-	}
-
-	repo := &Repository{
-		BasePath: path,
-		Worktree: worktree,
-		gitRepo:  gitRepo,
-	}
-
-	return repo, nil
-}
-
-/*
- Stage changes in repository
-*/
-func (self *Repository) StageChanges() error {
-	_, err := self.Worktree.Add(".")
+	_, err = self.Worktree.Add(".")
 	return err
 }
 
@@ -142,11 +127,13 @@ func (self *Repository) StageChanges() error {
  Commit a change in the repository
 */
 func (self *Repository) Commit(reason string) error {
+	name, email := self.defaultIdentity()
+
 	_, err := self.Worktree.Commit(
 		reason, &git.CommitOptions{
 			Author: &object.Signature{
-				Name:  "gitbase",
-				Email: "git@gitbase",
+				Name:  name,
+				Email: email,
 				When:  time.Now(),
 			},
 		})
@@ -166,6 +153,10 @@ func (self *Repository) CommitAll(reason string) error {
 
 /*
  Get all collections in the repository
+
+ Deprecated: this never walked the repository and always returned nil.
+ Use ListCollections, which is backed by the repository's cache, to
+ get the actual collection names.
 */
 func (self *Repository) Collections() []*Collection {
 
@@ -207,9 +198,15 @@ func (self *Repository) Put(key string, document []byte, reason string) error {
 	self.Lock()
 	defer self.Unlock()
 
-	path := filepath.Join(self.BasePath, key)
+	file, err := self.Worktree.Filesystem.Create(key)
+	if err != nil {
+		return err
+	}
 
-	err := ioutil.WriteFile(path, document, 0644)
+	_, err = file.Write(document)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
@@ -223,8 +220,7 @@ func (self *Repository) Put(key string, document []byte, reason string) error {
 Fetch a single document
 */
 func (self *Repository) Fetch(key string) ([]byte, error) {
-	path := filepath.Join(self.BasePath, key)
-	file, err := os.Open(path)
+	file, err := self.Worktree.Filesystem.Open(key)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -238,25 +234,43 @@ func (self *Repository) Fetch(key string) ([]byte, error) {
  Fetch a specific version of this document
 */
 func (self *Repository) FetchRevision(key, rev string) ([]byte, error) {
-	// This is a bit hackish because we are falling
-	// back to the git cli, as this is not (yet) implemented
-	// in go-git. At least as far I could see.
-	// Maybe add this.
-	return GitShow(self.BasePath, key, rev)
+	hash, err := self.gitRepo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := self.gitRepo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := tree.File(key)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(contents), nil
 }
 
 /*
 Remove a document
 */
 func (self *Repository) Remove(key string, reason string) error {
-	// Derive path
-	path := filepath.Join(self.BasePath, key)
-
 	self.Lock()
 	defer self.Unlock()
 
-	// Remove from filesystem
-	err := os.Remove(path)
+	// Remove from the worktree
+	err := self.Worktree.Filesystem.Remove(key)
 
 	if err != nil {
 		return err
@@ -286,9 +300,215 @@ func (self *Repository) Revisions(key string) ([]string, error) {
 
 /*
 Get commit history
+
+Walks the commit graph with go-git's log machinery, restarting the
+walk under the previous name whenever a rename is detected so history
+survives a `git mv`, much like `git log --follow`.
 */
 func (self *Repository) History(key string) ([]*Commit, error) {
-	// Again, this is a bit hackish because we are falling
-	// back to the git cli, as go-git does not support git log --follow
-	return GitHistory(self.BasePath, key)
+	history := []*Commit{}
+
+	head, err := self.gitRepo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	name := key
+	from := head.Hash()
+
+	for {
+		commitIter, err := self.gitRepo.Log(&git.LogOptions{
+			From:     from,
+			FileName: &name,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var last *object.Commit
+		err = commitIter.ForEach(func(c *object.Commit) error {
+			history = append(history, &Commit{
+				Id:      c.Hash.String(),
+				Message: c.Message,
+				When:    c.Author.When,
+			})
+			last = c
+			return nil
+		})
+		commitIter.Close()
+		// go-git's file-filtered CommitIter, unlike its other CommitIter
+		// implementations, surfaces running off the end of history as an
+		// io.EOF from ForEach itself rather than swallowing it.
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if last == nil {
+			break
+		}
+
+		oldName, oldCommit, renamed := renameSource(last, name)
+		if !renamed {
+			break
+		}
+
+		name = oldName
+		from = oldCommit.Hash
+	}
+
+	return history, nil
+}
+
+/*
+ renameSimilarityThreshold is the minimum line-overlap score, as
+ computed by lineSimilarity, for a delete+insert pair to be treated as
+ a rename rather than two unrelated changes.
+*/
+const renameSimilarityThreshold = 0.5
+
+/*
+ renameSource inspects the single-parent diff of commit and reports
+ whether key was introduced there as a rename of another path. go-git
+ v4's object.DiffTree does not detect renames itself (it only reports
+ Insert/Delete/Modify), so a deleted path is paired with key's insert
+ by exact blob hash first and, failing that, by line-content
+ similarity - the same signal `git diff -M` renames on. If a match is
+ found, it returns the previous path and the parent commit to resume
+ the walk from.
+*/
+func renameSource(commit *object.Commit, key string) (string, *object.Commit, bool) {
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return "", nil, false
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", nil, false
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return "", nil, false
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return "", nil, false
+	}
+
+	var inserted *object.ChangeEntry
+	deletes := []object.ChangeEntry{}
+
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			continue
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			if change.To.Name == key {
+				entry := change.To
+				inserted = &entry
+			}
+		case merkletrie.Delete:
+			deletes = append(deletes, change.From)
+		}
+	}
+
+	if inserted == nil || len(deletes) == 0 {
+		return "", nil, false
+	}
+
+	oldName, ok := bestRenameMatch(*inserted, deletes)
+	if !ok {
+		return "", nil, false
+	}
+
+	return oldName, parent, true
+}
+
+/*
+ bestRenameMatch picks the delete entry most likely to be the previous
+ name of inserted, or false if none of them are similar enough.
+*/
+func bestRenameMatch(inserted object.ChangeEntry, deletes []object.ChangeEntry) (string, bool) {
+	for _, del := range deletes {
+		if del.TreeEntry.Hash == inserted.TreeEntry.Hash {
+			// Unmodified rename: identical blob, different path.
+			return del.Name, true
+		}
+	}
+
+	insertedLines, err := changeEntryLines(inserted)
+	if err != nil {
+		return "", false
+	}
+
+	var bestName string
+	var bestScore float64
+
+	for _, del := range deletes {
+		delLines, err := changeEntryLines(del)
+		if err != nil {
+			continue
+		}
+
+		if score := lineSimilarity(delLines, insertedLines); score > bestScore {
+			bestScore = score
+			bestName = del.Name
+		}
+	}
+
+	if bestScore < renameSimilarityThreshold {
+		return "", false
+	}
+
+	return bestName, true
+}
+
+func changeEntryLines(entry object.ChangeEntry) ([]string, error) {
+	file, err := entry.Tree.TreeEntryFile(&entry.TreeEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(contents, "\n"), nil
+}
+
+/*
+ lineSimilarity is the fraction of lines shared between a and b,
+ relative to the longer of the two - 1.0 for identical content, 0.0
+ for disjoint content.
+*/
+func lineSimilarity(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	counts := map[string]int{}
+	for _, line := range a {
+		counts[line]++
+	}
+
+	shared := 0
+	for _, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+			shared++
+		}
+	}
+
+	longer := len(a)
+	if len(b) > longer {
+		longer = len(b)
+	}
+
+	return float64(shared) / float64(longer)
 }
@@ -0,0 +1,43 @@
+package gitbase
+
+import "testing"
+
+func TestHistoryFollowsRename(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("alpha"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	fs := repo.Worktree.Filesystem
+	if err := fs.Remove("programs/1"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	f, err := fs.Create("programs/2")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.Write([]byte("alpha")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := repo.CommitAll("rename program"); err != nil {
+		t.Fatalf("CommitAll() error = %v", err)
+	}
+
+	history, err := repo.History("programs/2")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("History(programs/2) len = %d, want 2 (rename + original add)", len(history))
+	}
+}
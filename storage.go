@@ -0,0 +1,94 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+
+	"log"
+	"os"
+)
+
+/*
+ Open (or initialize) a repository against an arbitrary go-git
+ storage.Storer and billy worktree filesystem. NewRepository and
+ NewMemoryRepository are both built on this.
+*/
+func NewRepositoryWithStorage(storer storage.Storer, worktree billy.Filesystem) (*Repository, error) {
+	gitRepo, err := git.Open(storer, worktree)
+	if err == git.ErrRepositoryNotExists {
+		gitRepo, err = git.Init(storer, worktree)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{
+		gitRepo: gitRepo,
+	}
+
+	if worktree != nil {
+		repo.BasePath = worktree.Root()
+
+		repo.Worktree, err = gitRepo.Worktree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return repo, nil
+}
+
+/*
+ An ephemeral repository backed entirely by memory, with no filesystem
+ footprint. Useful for tests.
+*/
+func NewMemoryRepository() (*Repository, error) {
+	return NewRepositoryWithStorage(memory.NewStorage(), memfs.New())
+}
+
+/*
+ Open and (if needed) initialize repository at an on-disk path. This
+ is a thin wrapper around NewRepositoryWithStorage for the common case
+ of a plain, OS-filesystem-backed repository.
+*/
+func NewRepository(path string) (*Repository, error) {
+
+	// Assert path exists
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	worktree := osfs.New(path)
+
+	dot, err := worktree.Chroot(".git")
+	if err != nil {
+		return nil, err
+	}
+
+	storer := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
+
+	if _, err := git.Open(storer, worktree); err == git.ErrRepositoryNotExists {
+		log.Println("Initializing repository:", path)
+		if err := repositoryCanInitialize(path); err != nil {
+			// Path exists, but we can not initialize
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	repo, err := NewRepositoryWithStorage(storer, worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.onDisk = true
+	return repo, nil
+}
@@ -0,0 +1,34 @@
+package gitbase
+
+import "testing"
+
+func TestMemoryRepositoryPutFetch(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if err := repo.Put("programs/1", []byte("hello"), "add program"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := repo.Fetch("programs/1")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	if string(got) != "hello" {
+		t.Fatalf("Fetch() = %q, want %q", got, "hello")
+	}
+}
+
+func TestMemoryRepositoryIsNotOnDisk(t *testing.T) {
+	repo, err := NewMemoryRepository()
+	if err != nil {
+		t.Fatalf("NewMemoryRepository() error = %v", err)
+	}
+
+	if repo.onDisk {
+		t.Fatal("NewMemoryRepository() repo.onDisk = true, want false")
+	}
+}